@@ -0,0 +1,264 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ggstat
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/aclements/go-gg/generic"
+	"github.com/aclements/go-gg/table"
+	"github.com/aclements/go-moremath/stats"
+)
+
+// bootstrapSeed is a process-wide counter used to derive distinct,
+// per-call seeds for the *rand.Rand instances Density and Bootstrap
+// create when no explicit Rand is given. This keeps the default case
+// off of the global math/rand source, so independent calls (in
+// particular, independent calls made concurrently by a parallel
+// table.MapTables) don't contend or interfere with each other.
+var bootstrapSeed int64
+
+// newDefaultRand returns a new, independently seeded *rand.Rand for
+// use when no explicit source of randomness was given.
+func newDefaultRand() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano() + atomic.AddInt64(&bootstrapSeed, 1)))
+}
+
+// perGroupRands returns an independent *rand.Rand for each of gids,
+// one per group, suitable for use concurrently by the per-group
+// goroutines of a parallel table.MapTables.
+//
+// *rand.Rand is not itself safe for concurrent use, so a single,
+// shared Rand cannot be handed to every group's goroutine. Instead,
+// perGroupRands draws one seed per group, in order, from src (or, if
+// src is nil, from a process-default source), and uses each seed to
+// create that group's own generator. This keeps resampling
+// reproducible when the caller supplies an explicit, deterministically
+// seeded src, while still giving every group a generator no other
+// goroutine touches.
+func perGroupRands(src *rand.Rand, gids []table.GroupID) map[table.GroupID]*rand.Rand {
+	if src == nil {
+		src = newDefaultRand()
+	}
+	rands := make(map[table.GroupID]*rand.Rand, len(gids))
+	for _, gid := range gids {
+		rands[gid] = rand.New(rand.NewSource(src.Int63()))
+	}
+	return rands
+}
+
+// resolveConfLevel applies the default value for a ConfLevel field
+// (0 becomes 0.95) and checks that the result is a valid confidence
+// level, panicking with a clear message otherwise.
+func resolveConfLevel(confLevel float64) float64 {
+	if confLevel == 0 {
+		return 0.95
+	}
+	if confLevel <= 0 || confLevel >= 1 {
+		panic(fmt.Sprintf("ConfLevel must be in (0, 1), got %v", confLevel))
+	}
+	return confLevel
+}
+
+// Bootstrap constructs pointwise bootstrap confidence bands for the
+// output of an arbitrary transform by evaluating Transform on B
+// resampled versions of each group.
+//
+// Transform is required. Samples and ConfLevel have reasonable
+// default zero values.
+//
+// For each numeric ([]float64) output column of Transform, say "y",
+// the result contains three columns: "y", the pointwise mean of the
+// B evaluations of Transform; "y lo"; and "y hi", the pointwise
+// bounds of a ConfLevel confidence band. Non-numeric columns of
+// Transform's output are taken from Transform's output on the
+// unresampled group and passed through unchanged; they are not
+// compared across resamples.
+type Bootstrap struct {
+	// Transform is the transform to bootstrap. It is evaluated on B
+	// resampled versions of each group of the Grouping passed to
+	// this Bootstrap's F method, and must return a single-group
+	// Grouping of the same shape (row count and column set) for any
+	// resampling of a given input.
+	Transform func(table.Grouping) table.Grouping
+
+	// Samples is the number of bootstrap resamples, B, to evaluate
+	// Transform on for each group. If Samples is 0, a default of 200 is
+	// used.
+	Samples int
+
+	// ConfLevel is the confidence level of the confidence bands,
+	// e.g., 0.95 for a 95% confidence band. If ConfLevel is 0, a
+	// default of 0.95 is used.
+	ConfLevel float64
+
+	// Rand is the seed source of randomness used to resample each
+	// group. Rand itself is only ever used sequentially (even when
+	// table.MapTables evaluates groups concurrently) to derive an
+	// independent *rand.Rand per group, so supplying an explicit,
+	// deterministically seeded Rand keeps resampling reproducible
+	// without the groups' goroutines contending over or corrupting a
+	// shared generator. If Rand is nil, a process-default source is
+	// used in its place.
+	Rand *rand.Rand
+}
+
+func (b Bootstrap) F(g table.Grouping) table.Grouping {
+	if b.Samples == 0 {
+		b.Samples = 200
+	}
+	confLevel := resolveConfLevel(b.ConfLevel)
+	rands := perGroupRands(b.Rand, g.Tables())
+
+	return table.MapTables(func(gid table.GroupID, t *table.Table) *table.Table {
+		rnd := rands[gid]
+
+		// Use the unresampled group to fix the shape of the
+		// output: its column set, row order, and which columns
+		// are numeric (and hence bootstrapped) versus passed
+		// through as-is.
+		ref := b.evalOn(t)
+		numeric := map[string][]float64{}
+		for _, name := range ref.Columns() {
+			if col, ok := ref.Column(name).([]float64); ok {
+				numeric[name] = col
+			}
+		}
+
+		reps := make(map[string][][]float64, len(numeric))
+		for name := range numeric {
+			reps[name] = make([][]float64, 0, b.Samples)
+		}
+		perm := make([]int, t.Len())
+		for i := 0; i < b.Samples; i++ {
+			for j := range perm {
+				perm[j] = rnd.Intn(t.Len())
+			}
+			rt := new(table.Table)
+			for _, name := range t.Columns() {
+				rt = rt.Add(name, generic.MultiIndex(t.Column(name), perm))
+			}
+			out := b.evalOn(rt)
+			for name := range numeric {
+				col, _ := out.Column(name).([]float64)
+				reps[name] = append(reps[name], col)
+			}
+		}
+
+		nt := new(table.Table)
+		row := make([]float64, 0, b.Samples)
+		for _, name := range ref.Columns() {
+			col, ok := numeric[name]
+			if !ok {
+				nt = nt.Add(name, ref.Column(name))
+				continue
+			}
+
+			mean, lo, hi := make([]float64, len(col)), make([]float64, len(col)), make([]float64, len(col))
+			for i := range col {
+				row = row[:0]
+				for _, rep := range reps[name] {
+					if i < len(rep) {
+						row = append(row, rep[i])
+					}
+				}
+				mean[i], lo[i], hi[i] = bootstrapBand(row, confLevel)
+			}
+			nt = nt.Add(name, mean).Add(name+" lo", lo).Add(name+" hi", hi)
+		}
+		return nt
+	}, g)
+}
+
+// evalOn evaluates b.Transform on the single-group Grouping t and
+// returns its (also single-group) result as a *table.Table. It panics
+// if Transform does not return its result bound to table.RootGroupID,
+// since Transform is documented to return a single-group Grouping for
+// a single-group input.
+func (b Bootstrap) evalOn(t *table.Table) *table.Table {
+	out := b.Transform(t)
+	ot := out.Table(table.RootGroupID)
+	if ot == nil {
+		panic(fmt.Sprintf("ggstat.Bootstrap: Transform must return a single-group Grouping bound to table.RootGroupID, got groups %v", out.Tables()))
+	}
+	return ot
+}
+
+// bootstrapBand returns the mean of xs and the [lo, hi] bounds of a
+// confLevel confidence interval estimated from the empirical
+// quantiles of xs.
+func bootstrapBand(xs []float64, confLevel float64) (mean, lo, hi float64) {
+	if len(xs) == 0 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+
+	sum := 0.0
+	for _, x := range xs {
+		sum += x
+	}
+	mean = sum / float64(len(xs))
+
+	sorted := append([]float64{}, xs...)
+	sort.Float64s(sorted)
+	tail := (1 - confLevel) / 2
+	return mean, quantile(sorted, tail), quantile(sorted, 1-tail)
+}
+
+// quantile returns the p'th quantile (0 <= p <= 1) of the
+// already-sorted slice xs, linearly interpolating between the
+// closest ranks.
+func quantile(xs []float64, p float64) float64 {
+	if len(xs) == 1 {
+		return xs[0]
+	}
+	pos := p * float64(len(xs)-1)
+	lo := int(pos)
+	if lo < 0 {
+		lo = 0
+	}
+	hi := lo + 1
+	if hi >= len(xs) {
+		return xs[lo]
+	}
+	frac := pos - float64(lo)
+	return xs[lo]*(1-frac) + xs[hi]*frac
+}
+
+// resampleSample returns a bootstrap resample of sample: len(sample.Xs)
+// draws, with replacement, from sample.Xs, weighted by sample.Weights
+// (or uniformly, if sample.Weights is nil) treated as multinomial
+// probabilities.
+func resampleSample(rnd *rand.Rand, sample stats.Sample) stats.Sample {
+	n := len(sample.Xs)
+	xs := make([]float64, n)
+
+	if sample.Weights == nil {
+		for i := range xs {
+			xs[i] = sample.Xs[rnd.Intn(n)]
+		}
+		return stats.Sample{Xs: xs}
+	}
+
+	cum := make([]float64, n)
+	total := 0.0
+	for i, w := range sample.Weights {
+		total += w
+		cum[i] = total
+	}
+	for i := range xs {
+		target := rnd.Float64() * total
+		j := sort.Search(n, func(k int) bool { return cum[k] >= target })
+		if j >= n {
+			j = n - 1
+		}
+		xs[i] = sample.Xs[j]
+	}
+	return stats.Sample{Xs: xs}
+}
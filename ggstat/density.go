@@ -6,6 +6,7 @@ package ggstat
 
 import (
 	"math"
+	"math/rand"
 
 	"github.com/aclements/go-gg/table"
 	"github.com/aclements/go-moremath/stats"
@@ -28,6 +29,12 @@ import (
 // - If Cumulative is false, column "probability density" is the
 //   density estimate. If Cumulative is true, column "cumulative
 //   density" is the cumulative density estimate.
+//
+// If Bootstrap is greater than 0, the result also has two more
+// columns giving a pointwise bootstrap confidence band on the
+// density estimate: "probability density lo" and "probability
+// density hi" (or "cumulative density lo" and "cumulative density
+// hi", if Cumulative is true).
 type Density struct {
 	// X is the name of the column to use for samples.
 	X string
@@ -87,16 +94,30 @@ type Density struct {
 	// or Max to math.Inf(1).
 	BoundaryMin float64
 	BoundaryMax float64
+
+	// Bootstrap is the number of bootstrap resamples to use to
+	// compute a pointwise confidence band on the density estimate.
+	// If Bootstrap is 0, no confidence band is computed.
+	Bootstrap int
+
+	// ConfLevel is the confidence level of the bootstrap confidence
+	// band, e.g., 0.95 for a 95% confidence band. It is ignored if
+	// Bootstrap is 0. If ConfLevel is 0, a default of 0.95 is used;
+	// otherwise it must be in (0, 1), or F panics.
+	ConfLevel float64
+
+	// Rand is the seed source of randomness used for bootstrap
+	// resampling. It is ignored if Bootstrap is 0. Rand itself is
+	// only ever used sequentially (even when table.MapTables
+	// evaluates groups concurrently) to derive an independent
+	// *rand.Rand per group, so supplying an explicit, deterministically
+	// seeded Rand keeps resampling reproducible without the groups'
+	// goroutines contending over or corrupting a shared generator. If
+	// Rand is nil, a process-default source is used in its place.
+	Rand *rand.Rand
 }
 
 func (d Density) F(g table.Grouping) table.Grouping {
-	kde := stats.KDE{
-		Kernel:         d.Kernel,
-		Bandwidth:      d.Bandwidth,
-		BoundaryMethod: d.BoundaryMethod,
-		BoundaryMin:    d.BoundaryMin,
-		BoundaryMax:    d.BoundaryMax,
-	}
 	if d.N == 0 {
 		d.N = 200
 	}
@@ -108,6 +129,13 @@ func (d Density) F(g table.Grouping) table.Grouping {
 		resp = "cumulative density"
 	}
 
+	var rands map[table.GroupID]*rand.Rand
+	var confLevel float64
+	if d.Bootstrap > 0 {
+		confLevel = resolveConfLevel(d.ConfLevel)
+		rands = perGroupRands(d.Rand, g.Tables())
+	}
+
 	// Gather samples.
 	samples := map[table.GroupID]stats.Sample{}
 	for _, gid := range g.Tables() {
@@ -120,7 +148,7 @@ func (d Density) F(g table.Grouping) table.Grouping {
 		samples[gid] = sample
 	}
 
-	min, max := math.NaN(), math.NaN()
+	combinedMin, combinedMax := math.NaN(), math.NaN()
 	if !d.SplitGroups {
 		// Compute combined bounds.
 		for _, sample := range samples {
@@ -135,17 +163,32 @@ func (d Density) F(g table.Grouping) table.Grouping {
 			}
 
 			smin, smax = smin-d.Widen*bandwidth, smax+d.Widen*bandwidth
-			if smin < min || math.IsNaN(min) {
-				min = smin
+			if smin < combinedMin || math.IsNaN(combinedMin) {
+				combinedMin = smin
 			}
-			if smax > max || math.IsNaN(max) {
-				max = smax
+			if smax > combinedMax || math.IsNaN(combinedMax) {
+				combinedMax = smax
 			}
 		}
 	}
 
 	return table.MapTables(func(gid table.GroupID, t *table.Table) *table.Table {
-		kde.Sample = samples[gid]
+		// kde is local to this group's invocation of the callback:
+		// table.MapTables may evaluate groups concurrently, and a
+		// stats.KDE shared across groups would be a data race. (The
+		// min/max bounds below are likewise per-call copies.)
+		kde := stats.KDE{
+			Kernel:         d.Kernel,
+			Bandwidth:      d.Bandwidth,
+			BoundaryMethod: d.BoundaryMethod,
+			BoundaryMin:    d.BoundaryMin,
+			BoundaryMax:    d.BoundaryMax,
+			Sample:         samples[gid],
+		}
+
+		if kde.Sample.Weight() == 0 && d.Bootstrap > 0 {
+			return new(table.Table).Add(d.X, []float64{}).Add(resp, []float64{}).Add(resp+" lo", []float64{}).Add(resp+" hi", []float64{})
+		}
 
 		if kde.Sample.Weight() == 0 {
 			return new(table.Table).Add(d.X, []float64{}).Add(resp, []float64{})
@@ -155,6 +198,7 @@ func (d Density) F(g table.Grouping) table.Grouping {
 			kde.Bandwidth = stats.BandwidthScott(kde.Sample)
 		}
 
+		min, max := combinedMin, combinedMax
 		if d.SplitGroups {
 			// Compute group bounds.
 			min, max = kde.Sample.Bounds()
@@ -168,6 +212,35 @@ func (d Density) F(g table.Grouping) table.Grouping {
 		} else {
 			nt = nt.Add(resp, vec.Map(kde.PDF, ss))
 		}
+
+		if d.Bootstrap > 0 {
+			rnd := rands[gid]
+
+			reps := make([][]float64, d.Bootstrap)
+			for i := range reps {
+				rkde := kde
+				rkde.Sample = resampleSample(rnd, kde.Sample)
+				if d.Bandwidth == 0 {
+					rkde.Bandwidth = stats.BandwidthScott(rkde.Sample)
+				}
+				if d.Cumulative {
+					reps[i] = vec.Map(rkde.CDF, ss)
+				} else {
+					reps[i] = vec.Map(rkde.PDF, ss)
+				}
+			}
+
+			lo, hi := make([]float64, len(ss)), make([]float64, len(ss))
+			row := make([]float64, d.Bootstrap)
+			for j := range ss {
+				for i, rep := range reps {
+					row[i] = rep[j]
+				}
+				_, lo[j], hi[j] = bootstrapBand(row, confLevel)
+			}
+			nt = nt.Add(resp+" lo", lo).Add(resp+" hi", hi)
+		}
+
 		return nt
 	}, g)
 }
@@ -0,0 +1,259 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ggstat
+
+import (
+	"math"
+
+	"github.com/aclements/go-gg/table"
+)
+
+// KZ constructs a Kolmogorov-Zurbenko low-pass filter: an iterated
+// moving average that is effective at revealing trends in noisy
+// time-series data (for example, benchmark results plotted over
+// time) while suppressing high-frequency noise.
+//
+// X and Y are required. Window and Iterations have reasonable
+// default zero values.
+//
+// KZ sorts each group by X before smoothing, so the input need not
+// be ordered.
+//
+// The result of KZ has two columns: column X, in sorted order, and
+// column "kz", the smoothed Y series.
+type KZ struct {
+	// X is the name of the column to use for the ordered sample
+	// positions.
+	X string
+
+	// Y is the name of the column to use for the sample values.
+	Y string
+
+	// Window is the width, m, of the moving average window. If
+	// Window is 0, a default of 5 is used. The window is truncated
+	// at the edges of each group.
+	Window int
+
+	// Iterations is the number of times, k, the moving average is
+	// applied. If Iterations is 0, a default of 3 is used.
+	Iterations int
+}
+
+func (k KZ) F(g table.Grouping) table.Grouping {
+	if k.Window == 0 {
+		k.Window = 5
+	}
+	if k.Iterations == 0 {
+		k.Iterations = 3
+	}
+
+	g = table.SortBy(g, k.X)
+	return table.MapTables(func(_ table.GroupID, t *table.Table) *table.Table {
+		y := t.MustColumn(k.Y).([]float64)
+		smoothed := kz(y, k.Window, k.Iterations)
+		return new(table.Table).Add(k.X, t.MustColumn(k.X)).Add("kz", smoothed)
+	}, g)
+}
+
+// KZA constructs a Kolmogorov-Zurbenko Adaptive filter. Like KZ, it
+// is an iterated moving average, but it shrinks the averaging window
+// near points where the underlying trend appears to have a step or
+// other discontinuity, so the smoother does not blur across breaks
+// the way a plain KZ filter would.
+//
+// X and Y are required. Window, Iterations, and Adaptivity have
+// reasonable default zero values.
+//
+// KZA sorts each group by X before smoothing, so the input need not
+// be ordered.
+//
+// The result of KZA has two columns: column X, in sorted order, and
+// column "kza", the adaptively smoothed Y series.
+type KZA struct {
+	// X is the name of the column to use for the ordered sample
+	// positions.
+	X string
+
+	// Y is the name of the column to use for the sample values.
+	Y string
+
+	// Window is the width, m, of the base moving average window
+	// used both to estimate the underlying trend and as the
+	// maximum adaptive window size. If Window is 0, a default of 5
+	// is used.
+	Window int
+
+	// Iterations is the number of times, k, the base KZ filter is
+	// applied to estimate the underlying trend used to detect
+	// breaks. If Iterations is 0, a default of 3 is used.
+	Iterations int
+
+	// Adaptivity scales how far the averaging window is shrunk near
+	// a detected break. On each side of a point, the half-window
+	// shrinks from Window/2 toward 1 in proportion to Adaptivity
+	// times that side's break derivative relative to the largest
+	// break indicator in the group, and never below 1. At 1, a side
+	// shrinks all the way to 1 only where its derivative equals that
+	// largest indicator; larger values shrink more, smaller values
+	// less. If Adaptivity is 0, a default of 1 is used.
+	Adaptivity float64
+}
+
+func (k KZA) F(g table.Grouping) table.Grouping {
+	if k.Window == 0 {
+		k.Window = 5
+	}
+	if k.Iterations == 0 {
+		k.Iterations = 3
+	}
+	if k.Adaptivity == 0 {
+		k.Adaptivity = 1
+	}
+
+	g = table.SortBy(g, k.X)
+	return table.MapTables(func(_ table.GroupID, t *table.Table) *table.Table {
+		y := t.MustColumn(k.Y).([]float64)
+		smoothed := kza(y, k.Window, k.Iterations, k.Adaptivity)
+		return new(table.Table).Add(k.X, t.MustColumn(k.X)).Add("kza", smoothed)
+	}, g)
+}
+
+// movingAverage returns the simple moving average of y using a
+// window of radius r (that is, a window width of 2r+1 truncated at
+// the edges of y). NaNs in y are treated as missing and excluded
+// from the mean; if a window contains only NaNs, the result at that
+// point is NaN.
+func movingAverage(y []float64, r int) []float64 {
+	out := make([]float64, len(y))
+	for i := range y {
+		lo, hi := i-r, i+r
+		if lo < 0 {
+			lo = 0
+		}
+		if hi >= len(y) {
+			hi = len(y) - 1
+		}
+
+		sum, n := 0.0, 0
+		for j := lo; j <= hi; j++ {
+			if !math.IsNaN(y[j]) {
+				sum += y[j]
+				n++
+			}
+		}
+		if n == 0 {
+			out[i] = math.NaN()
+		} else {
+			out[i] = sum / float64(n)
+		}
+	}
+	return out
+}
+
+// kz applies the Kolmogorov-Zurbenko low-pass filter to y: a moving
+// average of window m (window radius m/2), iterated k times.
+func kz(y []float64, m, k int) []float64 {
+	out := movingAverage(y, m/2)
+	for i := 1; i < k; i++ {
+		out = movingAverage(out, m/2)
+	}
+	return out
+}
+
+// kza applies the Kolmogorov-Zurbenko Adaptive filter to y, as
+// described by KZA.
+func kza(y []float64, m, k int, adaptivity float64) []float64 {
+	r := m / 2
+	if r == 0 {
+		return kz(y, m, k)
+	}
+
+	// Use the base KZ filter as an estimate of the underlying
+	// trend, and use how much it changes across the full window m
+	// centered on each point as an indicator of a nearby break:
+	// d_i = |KZ(y, m, k)_{i+m} - KZ(y, m, k)_{i-m}|.
+	trend := kz(y, m, k)
+	d := make([]float64, len(y))
+	dmax := 0.0
+	for i := range y {
+		lo, hi := i-m, i+m
+		if lo < 0 || hi >= len(y) {
+			continue
+		}
+		d[i] = math.Abs(trend[hi] - trend[lo])
+		if d[i] > dmax {
+			dmax = d[i]
+		}
+	}
+	if dmax == 0 {
+		// The trend doesn't change anywhere, so there are no
+		// breaks to preserve; the base filter is already as
+		// adaptive as it needs to be.
+		return trend
+	}
+
+	out := make([]float64, len(y))
+	for i := range y {
+		left := adaptiveHalfWindow(d, i, -1, m, r, dmax, adaptivity)
+		right := adaptiveHalfWindow(d, i, 1, m, r, dmax, adaptivity)
+
+		lo, hi := i-left, i+right
+		if lo < 0 {
+			lo = 0
+		}
+		if hi >= len(y) {
+			hi = len(y) - 1
+		}
+
+		sum, n := 0.0, 0
+		for j := lo; j <= hi; j++ {
+			if !math.IsNaN(y[j]) {
+				sum += y[j]
+				n++
+			}
+		}
+		if n == 0 {
+			out[i] = math.NaN()
+		} else {
+			out[i] = sum / float64(n)
+		}
+	}
+	return out
+}
+
+// adaptiveHalfWindow returns the adaptive half-window size to use on
+// one side of point i (dir is -1 for the left side or +1 for the
+// right side), shrinking from the full radius r toward 1, scaled by
+// adaptivity. d'_i, the one-sided discrete derivative of the break
+// indicator d on this side (d[i+breakDist]-d[i] on the right,
+// d[i]-d[i-breakDist] on the left), signals a probable step when it
+// is positive: the larger it is, the more the window is shrunk to
+// avoid blurring across the break. Where d'_i is not positive, or
+// the point breakDist away lies outside d, the full radius r is
+// used. d'_i is
+// sampled breakDist away from i, the same m-offset used to compute d
+// itself, so the derivative is measured at the scale the break
+// indicator was defined at.
+func adaptiveHalfWindow(d []float64, i, dir, breakDist, r int, dmax, adaptivity float64) int {
+	j := i + dir*breakDist
+	if j < 0 || j >= len(d) {
+		return r
+	}
+
+	deriv := (d[j] - d[i]) * float64(dir)
+	if deriv <= 0 {
+		return r
+	}
+
+	frac := deriv / dmax * adaptivity
+	if frac > 1 {
+		frac = 1
+	}
+	w := r - int(frac*float64(r-1)+0.5)
+	if w < 1 {
+		w = 1
+	}
+	return w
+}
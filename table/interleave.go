@@ -0,0 +1,151 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package table
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/aclements/go-gg/generic"
+)
+
+// Interleave returns a single-group Grouping that row-interleaves the
+// groups of g: row i*N+k of the result is row i of the k'th group of
+// g (in the order returned by g.Tables()), for each of the named
+// columns. If cols is empty, all of g's columns are interleaved.
+//
+// All of g's groups must have the same number of rows, and, for each
+// of cols, the same column type, or Interleave panics. A column whose
+// value is the same constant in every group is preserved as a single
+// constant column in the result, rather than being interleaved.
+//
+// Interleave is the row-oriented complement to the grouping
+// operations: where those split a Table into groups, Interleave zips
+// equal-length groups back together into a single, longer Table.
+func Interleave(g Grouping, cols ...string) Grouping {
+	if len(cols) == 0 {
+		cols = g.Columns()
+	}
+	return Grouping(interleave(g, g.Tables(), cols))
+}
+
+// InterleaveTables is like Interleave, but interleaves exactly the
+// groups named by gids, in the given order, and interleaves all of
+// their columns.
+func InterleaveTables(g Grouping, gids ...GroupID) *Table {
+	return interleave(g, gids, g.Columns())
+}
+
+// InterleaveByKey is like Interleave, but rather than matching up
+// rows by position, it first sorts each group by the named key
+// column and matches up rows by the value of key. All of g's groups
+// must have exactly the same multiset of key values, or
+// InterleaveByKey panics.
+func InterleaveByKey(g Grouping, key string, cols ...string) Grouping {
+	g = SortBy(g, key)
+	gids := g.Tables()
+	if len(cols) == 0 {
+		cols = g.Columns()
+	}
+
+	if len(gids) > 1 {
+		t0 := g.Table(gids[0])
+		k0 := t0.MustColumn(key)
+		for _, gid := range gids[1:] {
+			k1 := g.Table(gid).MustColumn(key)
+			if !reflect.DeepEqual(k0, k1) {
+				panic(fmt.Sprintf("cannot interleave by key %q: groups do not have matching key values", key))
+			}
+		}
+	}
+
+	return Grouping(interleave(g, gids, cols))
+}
+
+// interleave is the shared implementation of Interleave,
+// InterleaveTables, and InterleaveByKey: it row-interleaves the
+// tables named by gids, for the named cols.
+func interleave(g Grouping, gids []GroupID, cols []string) *Table {
+	if len(gids) == 0 {
+		return new(Table)
+	}
+
+	tables := make([]*Table, len(gids))
+	for i, gid := range gids {
+		t := g.Table(gid)
+		if t == nil {
+			panic(fmt.Sprintf("no such group %v", gid))
+		}
+		tables[i] = t
+	}
+
+	n := tables[0].Len()
+	for _, t := range tables[1:] {
+		if t.Len() != n {
+			panic(fmt.Sprintf("cannot interleave tables of different lengths: %d and %d", n, t.Len()))
+		}
+	}
+
+	nt := new(Table)
+	for _, name := range cols {
+		// If this column is a constant that agrees across all of
+		// the tables, preserve it as a single constant column
+		// rather than interleaving it.
+		if cv, ok := tables[0].Const(name); ok {
+			agree := true
+			for _, t := range tables[1:] {
+				cv2, ok := t.Const(name)
+				if !ok || !reflect.DeepEqual(cv, cv2) {
+					agree = false
+					break
+				}
+			}
+			if agree {
+				nt = nt.AddConst(name, cv)
+				continue
+			}
+		}
+
+		seqs := make([]Slice, len(tables))
+		for i, t := range tables {
+			seqs[i] = t.MustColumn(name)
+		}
+		nt = nt.Add(name, interleaveColumn(seqs))
+	}
+	return nt
+}
+
+// interleaveColumn returns a single Slice that row-interleaves the
+// Slices in cols: element i*len(cols)+k of the result is element i of
+// cols[k]. All of cols must have the same length and element type:
+// interleaveColumn panics with a *generic.TypeError if the element
+// types differ, and with a message naming both lengths if the lengths
+// differ.
+func interleaveColumn(cols []Slice) Slice {
+	rv0 := reflectSlice(cols[0])
+	n := rv0.Len()
+	elemType := rv0.Type().Elem()
+
+	rvs := make([]reflect.Value, len(cols))
+	rvs[0] = rv0
+	for i, c := range cols[1:] {
+		rv := reflectSlice(c)
+		if rv.Type().Elem() != elemType {
+			panic(&generic.TypeError{elemType, rv.Type().Elem(), "cannot be interleaved"})
+		}
+		if rv.Len() != n {
+			panic(fmt.Sprintf("cannot interleave columns of different lengths: %d and %d", n, rv.Len()))
+		}
+		rvs[i+1] = rv
+	}
+
+	out := reflect.MakeSlice(reflect.SliceOf(elemType), n*len(cols), n*len(cols))
+	for i := 0; i < n; i++ {
+		for k, rv := range rvs {
+			out.Index(i*len(cols) + k).Set(rv.Index(i))
+		}
+	}
+	return out.Interface()
+}